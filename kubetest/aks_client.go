@@ -0,0 +1,156 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/services/containerservice/mgmt/2020-03-01/containerservice"
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+var (
+	acsAgentPoolName     = flag.String("acsengine-agentpool-name", "agentpool0", "Name of the AKS agent pool")
+	acsNetworkPluginAKS  = flag.String("aks-networkPlugin", "azure", "Network plugin to use for the AKS cluster")
+	acsKubernetesVersion = flag.String("aks-kubernetes-version", "", "Kubernetes version for the AKS managed control plane")
+)
+
+// AKSClient wraps an AzureClient with the extra clients needed to talk to
+// the armcontainerservice (AKS) control plane, the same way AzureClient
+// wraps the ARM clients used by the acs-engine path.
+type AKSClient struct {
+	*AzureClient
+	managedClustersClient containerservice.ManagedClustersClient
+}
+
+func getAKSClient(env azure.Environment, creds *Creds) (*AKSClient, error) {
+	azureClient, err := newAzureClientForCreds(env, creds)
+	if err != nil {
+		return nil, err
+	}
+	managedClustersClient := containerservice.NewManagedClustersClientWithBaseURI(env.ResourceManagerEndpoint, creds.SubscriptionID)
+	managedClustersClient.Authorizer = azureClient.authorizer
+	return &AKSClient{
+		AzureClient:           azureClient,
+		managedClustersClient: managedClustersClient,
+	}, nil
+}
+
+// ManagedClusterSpec is the subset of AKS managed cluster configuration
+// that kubetest cares about, translated from the --acsengine-* /
+// --aks-* flags.
+type ManagedClusterSpec struct {
+	Location               string
+	KubernetesVersion      string
+	DNSPrefix              string
+	NetworkPlugin          string
+	AgentPoolName          string
+	AgentVMSize            string
+	AgentCount             int32
+	AgentOSType            string
+	AdminUsername          string
+	AdminPassword          string
+	ServicePrincipalID     string
+	ServicePrincipalSecret string
+}
+
+func managedClusterSpecFromFlags(location string, creds *Creds) *ManagedClusterSpec {
+	return &ManagedClusterSpec{
+		Location:               location,
+		KubernetesVersion:      *acsKubernetesVersion,
+		DNSPrefix:              *acsDnsPrefix,
+		NetworkPlugin:          *acsNetworkPluginAKS,
+		AgentPoolName:          *acsAgentPoolName,
+		AgentVMSize:            *acsAgentVmSize,
+		AgentCount:             int32(*acsAgentPoolCount),
+		AgentOSType:            *acsAgentOSType,
+		AdminUsername:          *acsAdminUsername,
+		AdminPassword:          *acsAdminPassword,
+		ServicePrincipalID:     creds.ClientID,
+		ServicePrincipalSecret: creds.ClientSecret,
+	}
+}
+
+// linuxSystemPoolName is the forced default pool every AKS cluster gets:
+// AKS requires the "System" mode pool to run Linux, so a Windows
+// spec.AgentPoolName can only ever be added as a second, "User" mode pool.
+const linuxSystemPoolName = "systempool"
+
+func (c *AKSClient) CreateManagedCluster(ctx context.Context, resourceGroup, name string, spec *ManagedClusterSpec) error {
+	dnsPrefix := spec.DNSPrefix
+	if dnsPrefix == "" {
+		dnsPrefix = name
+	}
+	systemPoolName := linuxSystemPoolName
+	systemPoolCount := int32(1)
+	agentPools := []containerservice.ManagedClusterAgentPoolProfile{
+		{
+			Name:   &systemPoolName,
+			Count:  &systemPoolCount,
+			VMSize: containerservice.VMSizeTypes(spec.AgentVMSize),
+			OsType: containerservice.Linux,
+			Mode:   containerservice.System,
+		},
+		{
+			Name:   &spec.AgentPoolName,
+			Count:  &spec.AgentCount,
+			VMSize: containerservice.VMSizeTypes(spec.AgentVMSize),
+			OsType: containerservice.OSType(spec.AgentOSType),
+			Mode:   containerservice.User,
+		},
+	}
+	properties := &containerservice.ManagedClusterProperties{
+		DNSPrefix:         &dnsPrefix,
+		KubernetesVersion: &spec.KubernetesVersion,
+		AgentPoolProfiles: &agentPools,
+		NetworkProfile: &containerservice.NetworkProfileType{
+			NetworkPlugin: containerservice.NetworkPlugin(spec.NetworkPlugin),
+		},
+		ServicePrincipalProfile: &containerservice.ManagedClusterServicePrincipalProfile{
+			ClientID: &spec.ServicePrincipalID,
+			Secret:   &spec.ServicePrincipalSecret,
+		},
+	}
+	if spec.AgentOSType == string(containerservice.Windows) {
+		properties.WindowsProfile = &containerservice.ManagedClusterWindowsProfile{
+			AdminUsername: &spec.AdminUsername,
+			AdminPassword: &spec.AdminPassword,
+		}
+	}
+	cluster := containerservice.ManagedCluster{
+		Location:                 &spec.Location,
+		ManagedClusterProperties: properties,
+	}
+	future, err := c.managedClustersClient.CreateOrUpdate(ctx, resourceGroup, name, cluster)
+	if err != nil {
+		return err
+	}
+	return future.WaitForCompletionRef(ctx, c.managedClustersClient.Client)
+}
+
+func (c *AKSClient) GetManagedClusterCredentials(ctx context.Context, resourceGroup, name string) ([]byte, error) {
+	result, err := c.managedClustersClient.ListClusterAdminCredentials(ctx, resourceGroup, name)
+	if err != nil {
+		return nil, err
+	}
+	if result.Kubeconfigs == nil || len(*result.Kubeconfigs) == 0 {
+		return nil, fmt.Errorf("no kubeconfig returned for cluster %v", name)
+	}
+	return *(*result.Kubeconfigs)[0].Value, nil
+}
@@ -32,9 +32,8 @@ import (
 	"strings"
 	"time"
 
-	"github.com/pelletier/go-toml"
-
 	"github.com/Azure/azure-storage-blob-go/2016-05-31/azblob"
+	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/satori/go.uuid"
 )
@@ -45,11 +44,11 @@ var (
 	acsResourceGroupName   = flag.String("acsengine-resourcegroup-name", "", "Azure Resource Group Name")
 	acsLocation            = flag.String("acsengine-location", "westus2", "Azure ACS location")
 	acsMasterVmSize        = flag.String("acsengine-mastervmsize", "Standard_D2s_v3", "Azure Master VM size")
-	acsAgentVmSize         = flag.String("acsengine-agentvmsize", "Standard_D2s_v3", "Azure Agent VM size")
+	acsAgentVmSize         = flag.String("acsengine-agentvmsize", "Standard_D2s_v3", "Deprecated: use --acsengine-agentpool. Azure Agent VM size")
 	acsAdminUsername       = flag.String("acsengine-admin-username", "", "Admin username")
 	acsAdminPassword       = flag.String("acsengine-admin-password", "", "Admin password")
-	acsAgentPoolCount      = flag.Int("acsengine-agentpoolcount", 2, "Azure Agent Pool Count")
-	acsAgentOSType         = flag.String("acsengine-agentOSType", "Windows", "OS Type of Agent Nodes. Options: Windows|Linux")
+	acsAgentPoolCount      = flag.Int("acsengine-agentpoolcount", 2, "Deprecated: use --acsengine-agentpool. Azure Agent Pool Count")
+	acsAgentOSType         = flag.String("acsengine-agentOSType", "Windows", "Deprecated: use --acsengine-agentpool. OS Type of Agent Nodes. Options: Windows|Linux")
 	acsTemplatePath        = flag.String("acsengine-template", "", "Azure Template Name")
 	acsDnsPrefix           = flag.String("acsengine-dnsprefix", "", "Azure K8s Master DNS Prefix")
 	acsEngineURL           = flag.String("acsengine-download-url", "", "Download URL for ACS engine")
@@ -61,6 +60,10 @@ var (
 	acsOrchestratorRelease = flag.String("acsengine-orchestratorRelease", "1.11", "Orchestrator Profile for acs-engine")
 	acsWinZipBuildScript   = flag.String("acsengine-winZipBuildScript", "https://raw.githubusercontent.com/Azure/acs-engine/master/scripts/build-windows-k8s.sh", "Build script to create custom zip containing win binaries for acs-engine")
 	acsNetworkPlugin       = flag.String("acsengine-networkPlugin", "azure", "Network pluging to use with acs-engine")
+	acsBootstrapperType    = flag.String("bootstrapper", "acs-engine", "Cluster bootstrapper to use. Options: acs-engine|kubeadm")
+	acsProvisioner         = flag.String("provisioner", "acs-engine", "Cluster provisioner. Options: acs-engine|aks")
+	azureEnvironmentName   = flag.String("azure-environment", "AzurePublicCloud", "Azure environment to deploy into. Options: AzurePublicCloud|AzureChinaCloud|AzureUSGovernmentCloud")
+	acsStorageAccountName  = flag.String("acsengine-storage-account-name", "", "Azure storage account name for uploading logs/binaries. Only needed when resolving credentials from something other than --acsengine-creds, which already carries its own storage account name.")
 )
 
 type Creds struct {
@@ -70,6 +73,11 @@ type Creds struct {
 	SubscriptionID     string
 	StorageAccountName string
 	StorageAccountKey  string
+	// Authorizer is set by credential sources that only ever resolve to a
+	// token (workload identity, managed identity) rather than a client
+	// secret, so ARM/AKS clients can be built straight from the token
+	// instead of assuming a secret is always available.
+	Authorizer autorest.Authorizer
 }
 
 type Config struct {
@@ -96,26 +104,25 @@ type Cluster struct {
 	acsCustomWinBinariesURL string
 	acsEngineBinaryPath     string
 	azureClient             *AzureClient
+	bootstrapper            Bootstrapper
 }
 
 func (c *Cluster) getAzCredentials() error {
-	content, err := ioutil.ReadFile(*acsCredentialsFile)
-	log.Printf("Reading credentials file %v", *acsCredentialsFile)
-	if err != nil {
-		return fmt.Errorf("Error reading credentials file %v %v", *acsCredentialsFile, err)
-	}
-	config := Config{}
-	err = toml.Unmarshal(content, &config)
-	c.credentials = &config.Creds
+	creds, err := getAzCredentialChain()
 	if err != nil {
-		return fmt.Errorf("Error parsing credentials file %v %v", *acsCredentialsFile, err)
+		return err
 	}
+	c.credentials = creds
 	return nil
 }
 
+// checkParams validates flags and fills in defaults before a cluster is
+// built. It also dry-runs the credential chain so a cluster with no
+// usable credential source fails fast here rather than partway through
+// newAcsEngine.
 func checkParams() error {
-	if *acsCredentialsFile == "" {
-		return fmt.Errorf("No credentials file path specified")
+	if _, err := getAzCredentialChain(); err != nil {
+		return err
 	}
 	if *acsResourceName == "" {
 		*acsResourceName = "kubetest-" + uuid.NewV1().String()
@@ -162,11 +169,21 @@ func newAcsEngine() (*Cluster, error) {
 		acsCustomWinBinariesURL: "",
 		acsEngineBinaryPath:     "acs-engine", // use the one in path by default
 	}
-	c.getAzCredentials()
+	if err := c.getAzCredentials(); err != nil {
+		return nil, fmt.Errorf("Error resolving Azure credentials: %v", err)
+	}
 	err = c.getARMClient(c.ctx)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to generate ARM client: %v", err)
 	}
+	switch *acsBootstrapperType {
+	case "kubeadm":
+		c.bootstrapper = newKubeadmBootstrapper(&c)
+	case "acs-engine":
+		c.bootstrapper = newAcsEngineBootstrapper(&c)
+	default:
+		return nil, fmt.Errorf("Unknown bootstrapper %q. Options: acs-engine|kubeadm", *acsBootstrapperType)
+	}
 	// like kops and gke set KUBERNETES_CONFORMANCE_TEST so the auth is picked up
 	// from kubectl instead of bash inference.
 	if err := os.Setenv("KUBERNETES_CONFORMANCE_TEST", "yes"); err != nil {
@@ -193,6 +210,7 @@ func (c *Cluster) generateTemplate() error {
 				OrchestratorRelease: *acsOrchestratorRelease,
 				KubernetesConfig: &KubernetesConfig{
 					NetworkPlugin: *acsNetworkPlugin,
+					Addons:        kubernetesAddons(),
 				},
 			},
 			MasterProfile: &MasterProfile{
@@ -201,25 +219,7 @@ func (c *Cluster) generateTemplate() error {
 				VMSize:         *acsMasterVmSize,
 				IPAddressCount: 200,
 			},
-			AgentPoolProfiles: []*AgentPoolProfile{
-				{
-					Name:                "agentpool0",
-					VMSize:              *acsAgentVmSize,
-					Count:               *acsAgentPoolCount,
-					OSType:              *acsAgentOSType,
-					AvailabilityProfile: "AvailabilitySet",
-					IPAddressCount:      200,
-					PreProvisionExtension: map[string]string{
-						"name":        "node_setup",
-						"singleOrAll": "all",
-					},
-					Extensions: []map[string]string{
-						{
-							"name": "winrm",
-						},
-					},
-				},
-			},
+			AgentPoolProfiles: agentPoolProfiles(),
 			LinuxProfile: &LinuxProfile{
 				AdminUsername: *acsAdminUsername,
 				SSHKeys: &SSH{
@@ -350,25 +350,31 @@ func (c *Cluster) loadARMTemplates() error {
 }
 
 func (c *Cluster) getARMClient(ctx context.Context) error {
-	env, err := azure.EnvironmentFromName("AzurePublicCloud")
-	var client *AzureClient
-	if client, err = getAzureClient(env,
-		c.credentials.SubscriptionID,
-		c.credentials.ClientID,
-		c.credentials.TenantID,
-		c.credentials.ClientSecret); err != nil {
+	env, err := azure.EnvironmentFromName(*azureEnvironmentName)
+	if err != nil {
+		return fmt.Errorf("Error looking up Azure environment %v: %v", *azureEnvironmentName, err)
+	}
+	client, err := newAzureClientForCreds(env, c.credentials)
+	if err != nil {
 		return fmt.Errorf("Error trying to get Azure Client: %v", err)
 	}
 	c.azureClient = client
 	return nil
 }
 
+// newAzureClientForCreds builds the ARM client from whichever credential
+// getAzCredentialChain resolved: a resolved token for workload/managed
+// identity (which never have a client secret to hand getAzureClient), or
+// the client secret directly for the env-var/TOML sources.
+func newAzureClientForCreds(env azure.Environment, creds *Creds) (*AzureClient, error) {
+	if creds.Authorizer != nil {
+		return getAzureClientWithAuthorizer(env, creds.SubscriptionID, creds.Authorizer)
+	}
+	return getAzureClient(env, creds.SubscriptionID, creds.ClientID, creds.TenantID, creds.ClientSecret)
+}
+
 func (c *Cluster) createCluster() error {
 	var err error
-	kubecfgDir, _ := ioutil.ReadDir(path.Join(c.outputDir, "kubeconfig"))
-	kubecfg := path.Join(c.outputDir, "kubeconfig", kubecfgDir[0].Name())
-	log.Printf("Setting kubeconfig env variable: kubeconfig path: %v.", kubecfg)
-	os.Setenv("KUBECONFIG", kubecfg)
 	log.Printf("Creating resurce group: %v.", c.resourceGroup)
 
 	_, err = c.azureClient.EnsureResourceGroup(c.ctx, c.resourceGroup, c.location, nil)
@@ -408,7 +414,10 @@ func (c *Cluster) buildHyperKube() error {
 
 func (c *Cluster) uploadZip(zipPath string) error {
 
-	credential := azblob.NewSharedKeyCredential(c.credentials.StorageAccountName, c.credentials.StorageAccountKey)
+	credential, err := storageCredential(c.credentials)
+	if err != nil {
+		return fmt.Errorf("Error getting storage credential: %v", err)
+	}
 	p := azblob.NewPipeline(credential, azblob.PipelineOptions{})
 
 	var containerName string = os.Getenv("AZ_STORAGE_CONTAINER_NAME")
@@ -478,54 +487,22 @@ func (c *Cluster) buildWinZip() error {
 }
 
 func (c Cluster) Up() error {
-
-	var err error
-	if *acsHyperKubeURL == "" {
-		err = c.buildHyperKube()
-		if err != nil {
-			return fmt.Errorf("Problem building hyperkube %v", err)
-		}
-	}
-	if *acsWinBinariesURL == "" {
-		err = c.buildWinZip()
-		if err != nil {
-			return fmt.Errorf("Problem building windowsZipFile %v", err)
-		}
-	}
-	if c.apiModelPath == "" {
-		err = c.generateTemplate()
-		if err != nil {
-			return fmt.Errorf("Failed to generate apiModel: %v", err)
-		}
-	}
-	if *acsEngineURL != "" {
-		err = c.getAcsEngine(2)
-		if err != nil {
-			return fmt.Errorf("Failed to get ACS Engine binary: %v", err)
-		}
-	}
-	err = c.generateARMTemplates()
-	if err != nil {
-		return fmt.Errorf("Failed to generate ARM templates: %v", err)
+	if err := c.bootstrapper.GenerateManifests(); err != nil {
+		return fmt.Errorf("Failed to generate manifests: %v", err)
 	}
-	err = c.loadARMTemplates()
-	if err != nil {
-		return fmt.Errorf("Error loading ARM templates: %v", err)
+	if err := c.bootstrapper.Deploy(); err != nil {
+		return fmt.Errorf("Error creating cluster: %v", err)
 	}
-	err = c.createCluster()
+	kubecfg, err := c.bootstrapper.FetchKubeconfig()
 	if err != nil {
-		return fmt.Errorf("Error creating cluster: %v", err)
+		return fmt.Errorf("Error fetching kubeconfig: %v", err)
 	}
-	return nil
+	log.Printf("Setting kubeconfig env variable: kubeconfig path: %v.", kubecfg)
+	return os.Setenv("KUBECONFIG", kubecfg)
 }
 
 func (c Cluster) Down() error {
-	log.Printf("Deleting resource group: %v.", c.resourceGroup)
-	return c.azureClient.DeleteResourceGroup(c.ctx, c.resourceGroup)
-}
-
-func (c Cluster) DumpClusterLogs(localPath, gcsPath string) error {
-	return nil
+	return c.bootstrapper.Teardown()
 }
 
 func (c Cluster) GetClusterCreated(clusterName string) (time.Time, error) {
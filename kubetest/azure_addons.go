@@ -0,0 +1,129 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// addonSpec is one --acsengine-addons value, e.g.
+// name=tiller,enabled=true,version=v2.11.0
+type addonSpec struct {
+	name    string
+	enabled bool
+	version string
+}
+
+// addonFlag collects repeated --acsengine-addons flags, the same way
+// agentPoolFlag collects --acsengine-agentpool.
+type addonFlag []*addonSpec
+
+func (f *addonFlag) String() string {
+	var names []string
+	for _, a := range *f {
+		names = append(names, a.name)
+	}
+	return strings.Join(names, ",")
+}
+
+func (f *addonFlag) Set(value string) error {
+	spec := &addonSpec{enabled: true}
+	for _, kv := range strings.Split(value, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("Invalid --acsengine-addons entry %q, expected key=value", kv)
+		}
+		key, val := parts[0], parts[1]
+		switch key {
+		case "name":
+			spec.name = val
+		case "enabled":
+			enabled, err := strconv.ParseBool(val)
+			if err != nil {
+				return fmt.Errorf("Invalid enabled in --acsengine-addons entry %q: %v", kv, err)
+			}
+			spec.enabled = enabled
+		case "version":
+			spec.version = val
+		default:
+			return fmt.Errorf("Unknown key %q in --acsengine-addons entry %q", key, kv)
+		}
+	}
+	if spec.name == "" {
+		return fmt.Errorf("--acsengine-addons entry %q is missing a name", value)
+	}
+	*f = append(*f, spec)
+	return nil
+}
+
+var acsAddons addonFlag
+
+func init() {
+	flag.Var(&acsAddons, "acsengine-addons",
+		"Repeatable. Enables or disables an acs-engine addon, e.g. name=tiller,enabled=true[,version=v2.11.0].")
+}
+
+// kubernetesAddons renders the configured --acsengine-addons into the
+// KubernetesConfig.Addons list of the generated api model.
+func kubernetesAddons() []*KubernetesAddon {
+	addons := make([]*KubernetesAddon, 0, len(acsAddons))
+	for _, spec := range acsAddons {
+		addons = append(addons, &KubernetesAddon{
+			Name:    spec.name,
+			Enabled: &spec.enabled,
+			Version: spec.version,
+		})
+	}
+	return addons
+}
+
+// HasAddon reports whether the given addon was requested and enabled via
+// --acsengine-addons, so test drivers can skip/require specs based on
+// the addons rendered into the generated api model instead of assuming a
+// fixed addon set. It reads only the flags: an api model given with
+// --acsengine-template is not consulted.
+func (c Cluster) HasAddon(name string) bool {
+	for _, spec := range acsAddons {
+		if spec.name == name {
+			return spec.enabled
+		}
+	}
+	return false
+}
+
+// HasDashboard reports whether the kubernetes-dashboard addon is enabled.
+func (c Cluster) HasDashboard() bool {
+	return c.HasAddon("kubernetes-dashboard")
+}
+
+// HasTiller reports whether the tiller addon is enabled.
+func (c Cluster) HasTiller() bool {
+	return c.HasAddon("tiller")
+}
+
+// HasACIConnector reports whether the aci-connector addon is enabled.
+func (c Cluster) HasACIConnector() bool {
+	return c.HasAddon("aci-connector")
+}
+
+// HasRescheduler reports whether the rescheduler addon is enabled.
+func (c Cluster) HasRescheduler() bool {
+	return c.HasAddon("rescheduler")
+}
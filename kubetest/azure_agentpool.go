@@ -0,0 +1,163 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// agentPoolSpec is one --acsengine-agentpool value, e.g.
+// name=win1,os=Windows,count=3,vmsize=Standard_D4s_v3,availability=VirtualMachineScaleSets,maxPods=50
+type agentPoolSpec struct {
+	name                         string
+	osType                       string
+	count                        int
+	vmSize                       string
+	availabilityProfile          string
+	maxPods                      int
+	storageProfile               string
+	acceleratedNetworkingEnabled bool
+}
+
+// agentPoolFlag collects repeated --acsengine-agentpool flags into a
+// slice of agentPoolSpec, one entry per flag occurrence.
+type agentPoolFlag []*agentPoolSpec
+
+func (f *agentPoolFlag) String() string {
+	var pools []string
+	for _, p := range *f {
+		pools = append(pools, p.name)
+	}
+	return strings.Join(pools, ",")
+}
+
+func (f *agentPoolFlag) Set(value string) error {
+	spec := &agentPoolSpec{
+		osType:              "Linux",
+		count:               3,
+		vmSize:              "Standard_D2s_v3",
+		availabilityProfile: "AvailabilitySet",
+	}
+	for _, kv := range strings.Split(value, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("Invalid --acsengine-agentpool entry %q, expected key=value", kv)
+		}
+		key, val := parts[0], parts[1]
+		switch key {
+		case "name":
+			spec.name = val
+		case "os":
+			spec.osType = val
+		case "count":
+			count, err := strconv.Atoi(val)
+			if err != nil {
+				return fmt.Errorf("Invalid count in --acsengine-agentpool entry %q: %v", kv, err)
+			}
+			spec.count = count
+		case "vmsize":
+			spec.vmSize = val
+		case "availability":
+			spec.availabilityProfile = val
+		case "maxPods":
+			maxPods, err := strconv.Atoi(val)
+			if err != nil {
+				return fmt.Errorf("Invalid maxPods in --acsengine-agentpool entry %q: %v", kv, err)
+			}
+			spec.maxPods = maxPods
+		case "storageProfile":
+			spec.storageProfile = val
+		case "acceleratedNetworking":
+			enabled, err := strconv.ParseBool(val)
+			if err != nil {
+				return fmt.Errorf("Invalid acceleratedNetworking in --acsengine-agentpool entry %q: %v", kv, err)
+			}
+			spec.acceleratedNetworkingEnabled = enabled
+		default:
+			return fmt.Errorf("Unknown key %q in --acsengine-agentpool entry %q", key, kv)
+		}
+	}
+	if spec.name == "" {
+		return fmt.Errorf("--acsengine-agentpool entry %q is missing a name", value)
+	}
+	*f = append(*f, spec)
+	return nil
+}
+
+// toAgentPoolProfile renders an agentPoolSpec into the ACS engine api
+// model type. The node_setup/winrm extensions only apply to Windows
+// pools, so Linux pools (e.g. a Linux system pool in a mixed-OS
+// deployment) are left without them.
+func (s *agentPoolSpec) toAgentPoolProfile() *AgentPoolProfile {
+	profile := &AgentPoolProfile{
+		Name:                         s.name,
+		VMSize:                       s.vmSize,
+		Count:                        s.count,
+		OSType:                       s.osType,
+		AvailabilityProfile:          s.availabilityProfile,
+		IPAddressCount:               200,
+		MaxPods:                      s.maxPods,
+		StorageProfile:               s.storageProfile,
+		AcceleratedNetworkingEnabled: s.acceleratedNetworkingEnabled,
+	}
+	if s.osType == "Windows" {
+		profile.PreProvisionExtension = map[string]string{
+			"name":        "node_setup",
+			"singleOrAll": "all",
+		}
+		profile.Extensions = []map[string]string{
+			{
+				"name": "winrm",
+			},
+		}
+	}
+	return profile
+}
+
+var acsAgentPools agentPoolFlag
+
+func init() {
+	flag.Var(&acsAgentPools, "acsengine-agentpool",
+		"Repeatable. Defines one agent pool, e.g. name=win1,os=Windows,count=3,vmsize=Standard_D4s_v3,"+
+			"availability=VirtualMachineScaleSets,maxPods=50[,storageProfile=...,acceleratedNetworking=true]. "+
+			"When unset, falls back to the single pool built from --acsengine-agentvmsize/--acsengine-agentpoolcount/--acsengine-agentOSType.")
+}
+
+// agentPoolProfiles returns the configured agent pools, or a single
+// pool built from the deprecated --acsengine-agentvmsize/
+// --acsengine-agentpoolcount/--acsengine-agentOSType flags when none
+// were given via --acsengine-agentpool.
+func agentPoolProfiles() []*AgentPoolProfile {
+	if len(acsAgentPools) > 0 {
+		profiles := make([]*AgentPoolProfile, 0, len(acsAgentPools))
+		for _, spec := range acsAgentPools {
+			profiles = append(profiles, spec.toAgentPoolProfile())
+		}
+		return profiles
+	}
+	legacy := &agentPoolSpec{
+		name:                "agentpool0",
+		osType:              *acsAgentOSType,
+		count:               *acsAgentPoolCount,
+		vmSize:              *acsAgentVmSize,
+		availabilityProfile: "AvailabilitySet",
+	}
+	return []*AgentPoolProfile{legacy.toAgentPoolProfile()}
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/satori/go.uuid"
+)
+
+// aksCluster provisions a managed AKS cluster via armcontainerservice
+// instead of rendering ARM templates through acs-engine. It implements
+// the same surface as Cluster so kubetest can pick either provisioner
+// with --provisioner.
+type aksCluster struct {
+	ctx           context.Context
+	credentials   *Creds
+	name          string
+	resourceGroup string
+	location      string
+	outputDir     string
+	aksClient     *AKSClient
+	spec          *ManagedClusterSpec
+}
+
+func newAksCluster() (*aksCluster, error) {
+	if *acsResourceName == "" {
+		*acsResourceName = "kubetest-" + uuid.NewV1().String()
+	}
+	if *acsResourceGroupName == "" {
+		*acsResourceGroupName = *acsResourceName + "-rg"
+	}
+
+	tempdir, err := ioutil.TempDir(os.Getenv("HOME"), "aks")
+	if err != nil {
+		return nil, fmt.Errorf("Error creating temp directory: %v", err)
+	}
+
+	c := &aksCluster{
+		ctx:           context.Background(),
+		name:          *acsResourceName,
+		resourceGroup: *acsResourceGroupName,
+		location:      *acsLocation,
+		outputDir:     tempdir,
+		credentials:   &Creds{},
+	}
+
+	creds, err := getAzCredentialChain()
+	if err != nil {
+		return nil, fmt.Errorf("Error resolving Azure credentials: %v", err)
+	}
+	c.credentials = creds
+
+	env, err := azure.EnvironmentFromName(*azureEnvironmentName)
+	if err != nil {
+		return nil, fmt.Errorf("Error looking up Azure environment %v: %v", *azureEnvironmentName, err)
+	}
+	aksClient, err := getAKSClient(env, c.credentials)
+	if err != nil {
+		return nil, fmt.Errorf("Error trying to get AKS client: %v", err)
+	}
+	c.aksClient = aksClient
+	c.spec = managedClusterSpecFromFlags(c.location, c.credentials)
+
+	if err := os.Setenv("KUBERNETES_CONFORMANCE_TEST", "yes"); err != nil {
+		return nil, err
+	}
+	if err := os.Setenv("KUBERNETES_CONFORMANCE_PROVIDER", "azure"); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *aksCluster) Up() error {
+	log.Printf("Creating resource group: %v.", c.resourceGroup)
+	if _, err := c.aksClient.EnsureResourceGroup(c.ctx, c.resourceGroup, c.location, nil); err != nil {
+		return fmt.Errorf("Could not ensure resource group: %v", err)
+	}
+	log.Printf("Creating AKS cluster %v in resource group %v.", c.name, c.resourceGroup)
+	if err := c.aksClient.CreateManagedCluster(c.ctx, c.resourceGroup, c.name, c.spec); err != nil {
+		return fmt.Errorf("Error creating AKS cluster: %v", err)
+	}
+	kubecfg, err := c.aksClient.GetManagedClusterCredentials(c.ctx, c.resourceGroup, c.name)
+	if err != nil {
+		return fmt.Errorf("Error fetching AKS kubeconfig: %v", err)
+	}
+	kubecfgPath := path.Join(c.outputDir, "kubeconfig")
+	if err := ioutil.WriteFile(kubecfgPath, kubecfg, 0644); err != nil {
+		return fmt.Errorf("Error writing kubeconfig: %v", err)
+	}
+	log.Printf("Setting kubeconfig env variable: kubeconfig path: %v.", kubecfgPath)
+	return os.Setenv("KUBECONFIG", kubecfgPath)
+}
+
+func (c *aksCluster) Down() error {
+	log.Printf("Deleting resource group: %v.", c.resourceGroup)
+	return c.aksClient.DeleteResourceGroup(c.ctx, c.resourceGroup)
+}
+
+func (c *aksCluster) DumpClusterLogs(localPath, gcsPath string) error {
+	return nil
+}
+
+func (c *aksCluster) GetClusterCreated(clusterName string) (time.Time, error) {
+	return time.Time{}, errors.New("not implemented")
+}
+
+func (c *aksCluster) TestSetup() error {
+	return nil
+}
+
+func (c *aksCluster) IsUp() error {
+	return isUp(c)
+}
+
+// Deployer is the surface both the acs-engine Cluster and the AKS-managed
+// aksCluster implement, so callers can pick either provisioner without
+// caring which one they got back.
+type Deployer interface {
+	Up() error
+	Down() error
+	IsUp() error
+	DumpClusterLogs(localPath, gcsPath string) error
+	GetClusterCreated(clusterName string) (time.Time, error)
+	TestSetup() error
+}
+
+// newAzureDeployer dispatches on --provisioner to construct the requested
+// Azure cluster provisioner.
+func newAzureDeployer() (Deployer, error) {
+	switch *acsProvisioner {
+	case "aks":
+		return newAksCluster()
+	case "acs-engine":
+		return newAcsEngine()
+	default:
+		return nil, fmt.Errorf("Unknown provisioner %q. Options: acs-engine|aks", *acsProvisioner)
+	}
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+)
+
+var (
+	kubeadmVersion        = flag.String("kubeadm-version", "", "kubeadm version to install on the control plane and nodes")
+	capzManifestURL       = flag.String("capz-manifest-url", "", "URL of the cluster-api-provider-azure manifest to apply")
+	capzKubernetesVersion = flag.String("capz-kubernetes-version", "", "Kubernetes version to pass to the capz cluster/machine templates")
+)
+
+// Bootstrapper provisions and tears down a Cluster. Cluster delegates all
+// of its actual provisioning work to one, so that Up()/Down()/IsUp() stay
+// stable while the mechanism underneath (acs-engine, kubeadm, ...) changes.
+type Bootstrapper interface {
+	// GenerateManifests renders whatever templates/api models the
+	// bootstrapper needs to deploy (ARM templates, capz manifests, ...).
+	GenerateManifests() error
+	// Deploy stands up the cluster from the generated manifests.
+	Deploy() error
+	// Teardown deletes everything Deploy created.
+	Teardown() error
+	// FetchKubeconfig returns a local path to a kubeconfig for the
+	// cluster, valid after Deploy has succeeded.
+	FetchKubeconfig() (string, error)
+}
+
+// acsEngineBootstrapper is the original bootstrapping flow: render ARM
+// templates with acs-engine, then deploy them through the Azure Resource
+// Manager. It just forwards to Cluster's existing acs-engine methods.
+type acsEngineBootstrapper struct {
+	cluster *Cluster
+}
+
+func newAcsEngineBootstrapper(c *Cluster) *acsEngineBootstrapper {
+	return &acsEngineBootstrapper{cluster: c}
+}
+
+func (b *acsEngineBootstrapper) GenerateManifests() error {
+	c := b.cluster
+	if *acsHyperKubeURL == "" {
+		if err := c.buildHyperKube(); err != nil {
+			return fmt.Errorf("Problem building hyperkube %v", err)
+		}
+	}
+	if *acsWinBinariesURL == "" {
+		if err := c.buildWinZip(); err != nil {
+			return fmt.Errorf("Problem building windowsZipFile %v", err)
+		}
+	}
+	if c.apiModelPath == "" {
+		if err := c.generateTemplate(); err != nil {
+			return fmt.Errorf("Failed to generate apiModel: %v", err)
+		}
+	}
+	if *acsEngineURL != "" {
+		if err := c.getAcsEngine(2); err != nil {
+			return fmt.Errorf("Failed to get ACS Engine binary: %v", err)
+		}
+	}
+	if err := c.generateARMTemplates(); err != nil {
+		return fmt.Errorf("Failed to generate ARM templates: %v", err)
+	}
+	if err := c.loadARMTemplates(); err != nil {
+		return fmt.Errorf("Error loading ARM templates: %v", err)
+	}
+	return nil
+}
+
+func (b *acsEngineBootstrapper) Deploy() error {
+	return b.cluster.createCluster()
+}
+
+func (b *acsEngineBootstrapper) Teardown() error {
+	c := b.cluster
+	log.Printf("Deleting resource group: %v.", c.resourceGroup)
+	return c.azureClient.DeleteResourceGroup(c.ctx, c.resourceGroup)
+}
+
+func (b *acsEngineBootstrapper) FetchKubeconfig() (string, error) {
+	c := b.cluster
+	kubecfgDir, err := ioutil.ReadDir(path.Join(c.outputDir, "kubeconfig"))
+	if err != nil {
+		return "", fmt.Errorf("Error reading kubeconfig directory: %v", err)
+	}
+	if len(kubecfgDir) == 0 {
+		return "", fmt.Errorf("No kubeconfig found under %v", path.Join(c.outputDir, "kubeconfig"))
+	}
+	return path.Join(c.outputDir, "kubeconfig", kubecfgDir[0].Name()), nil
+}
+
+// kubeadmBootstrapper stands up a cluster with kubeadm on top of
+// cluster-api-provider-azure (capz), skipping acs-engine and hyperkube
+// entirely: capz creates the VMs/VMSSes directly and kubeadm joins them.
+type kubeadmBootstrapper struct {
+	cluster       *Cluster
+	manifestPath  string
+	clusterctlBin string
+}
+
+func newKubeadmBootstrapper(c *Cluster) *kubeadmBootstrapper {
+	return &kubeadmBootstrapper{
+		cluster:       c,
+		clusterctlBin: "clusterctl",
+	}
+}
+
+func (b *kubeadmBootstrapper) GenerateManifests() error {
+	if *capzManifestURL == "" {
+		return fmt.Errorf("--capz-manifest-url is required for the kubeadm bootstrapper")
+	}
+	manifestPath := path.Join(b.cluster.outputDir, "capz-cluster.yaml")
+	f, err := os.Create(manifestPath)
+	if err != nil {
+		return fmt.Errorf("Error creating capz manifest file: %v", err)
+	}
+	defer f.Close()
+	if err := httpRead(*capzManifestURL, f); err != nil {
+		return fmt.Errorf("Error downloading capz manifest %v: %v", *capzManifestURL, err)
+	}
+	b.manifestPath = manifestPath
+	return nil
+}
+
+func (b *kubeadmBootstrapper) Deploy() error {
+	c := b.cluster
+	args := []string{"apply", "-f", b.manifestPath}
+	if *capzKubernetesVersion != "" {
+		args = append(args, "--kubernetes-version", *capzKubernetesVersion)
+	}
+	if *kubeadmVersion != "" {
+		args = append(args, "--kubeadm-version", *kubeadmVersion)
+	}
+	log.Printf("Deploying kubeadm cluster %v in resource group %v via capz.", c.name, c.resourceGroup)
+	return control.FinishRunning(exec.Command(b.clusterctlBin, args...))
+}
+
+func (b *kubeadmBootstrapper) Teardown() error {
+	log.Printf("Deleting capz cluster: %v.", b.cluster.name)
+	return control.FinishRunning(exec.Command(b.clusterctlBin, "delete", "cluster", b.cluster.name))
+}
+
+func (b *kubeadmBootstrapper) FetchKubeconfig() (string, error) {
+	c := b.cluster
+	kubecfg := path.Join(c.outputDir, "kubeconfig")
+	cmd := exec.Command(b.clusterctlBin, "get", "kubeconfig", c.name)
+	out, err := control.Output(cmd)
+	if err != nil {
+		return "", fmt.Errorf("Error fetching kubeconfig from clusterctl: %v", err)
+	}
+	if err := ioutil.WriteFile(kubecfg, out, 0644); err != nil {
+		return "", fmt.Errorf("Error writing kubeconfig: %v", err)
+	}
+	return kubecfg, nil
+}
@@ -0,0 +1,241 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-storage-blob-go/2016-05-31/azblob"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+
+	"github.com/pelletier/go-toml"
+)
+
+// credentialSource resolves a *Creds, or returns (nil, nil) when the
+// source simply doesn't apply (e.g. its env vars aren't set) so the
+// chain can move on to the next one without treating that as an error.
+type credentialSource func() (*Creds, error)
+
+// getAzCredentialChain tries, in order: plain env vars (matching what CI
+// workflows in the Azure ecosystem already export), workload-identity
+// federation, IMDS managed identity, and finally the legacy
+// --acsengine-creds TOML file. It only fails once every source has been
+// tried and none produced credentials.
+func getAzCredentialChain() (*Creds, error) {
+	sources := []struct {
+		name   string
+		source credentialSource
+	}{
+		{"environment variables", credsFromEnv},
+		{"workload identity", credsFromWorkloadIdentity},
+		{"managed identity", credsFromManagedIdentity},
+		{"credentials file", credsFromFile},
+	}
+	var tried []string
+	for _, s := range sources {
+		creds, err := s.source()
+		if err != nil {
+			log.Printf("Skipping %v credential source: %v", s.name, err)
+			tried = append(tried, s.name)
+			continue
+		}
+		if creds != nil {
+			log.Printf("Using Azure credentials from %v", s.name)
+			return creds, nil
+		}
+		tried = append(tried, s.name)
+	}
+	return nil, fmt.Errorf("No Azure credentials found. Tried: %v", strings.Join(tried, ", "))
+}
+
+// credsFromEnv reads AZURE_CLIENT_ID/AZURE_TENANT_ID/AZURE_CLIENT_SECRET/
+// AZURE_SUBSCRIPTION_ID, the same variables the az CLI and most Azure
+// GitHub Actions already export in CI.
+func credsFromEnv() (*Creds, error) {
+	clientID := os.Getenv("AZURE_CLIENT_ID")
+	tenantID := os.Getenv("AZURE_TENANT_ID")
+	clientSecret := os.Getenv("AZURE_CLIENT_SECRET")
+	subscriptionID := os.Getenv("AZURE_SUBSCRIPTION_ID")
+	if clientID == "" || tenantID == "" || clientSecret == "" || subscriptionID == "" {
+		return nil, nil
+	}
+	return &Creds{
+		ClientID:           clientID,
+		ClientSecret:       clientSecret,
+		TenantID:           tenantID,
+		SubscriptionID:     subscriptionID,
+		StorageAccountName: *acsStorageAccountName,
+	}, nil
+}
+
+// credsFromWorkloadIdentity exchanges the federated token AZURE_FEDERATED_TOKEN_FILE
+// points at for an AAD access token, the workload-identity flow used by
+// AKS pod identity and GitHub Actions OIDC.
+func credsFromWorkloadIdentity() (*Creds, error) {
+	tokenFile := os.Getenv("AZURE_FEDERATED_TOKEN_FILE")
+	clientID := os.Getenv("AZURE_CLIENT_ID")
+	tenantID := os.Getenv("AZURE_TENANT_ID")
+	subscriptionID := os.Getenv("AZURE_SUBSCRIPTION_ID")
+	if tokenFile == "" || clientID == "" || tenantID == "" || subscriptionID == "" {
+		return nil, nil
+	}
+	assertion, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading AZURE_FEDERATED_TOKEN_FILE %v: %v", tokenFile, err)
+	}
+	oauthConfig, err := adal.NewOAuthConfig(azurePublicCloudADEndpoint, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("Error building OAuth config: %v", err)
+	}
+	spt, err := adal.NewServicePrincipalTokenFromFederatedToken(*oauthConfig, clientID, string(assertion), azureManagementResource)
+	if err != nil {
+		return nil, fmt.Errorf("Error building service principal token from federated token: %v", err)
+	}
+	if err := spt.Refresh(); err != nil {
+		return nil, fmt.Errorf("Error exchanging federated token: %v", err)
+	}
+	return &Creds{
+		ClientID:           clientID,
+		TenantID:           tenantID,
+		SubscriptionID:     subscriptionID,
+		StorageAccountName: *acsStorageAccountName,
+		Authorizer:         autorest.NewBearerAuthorizer(spt),
+	}, nil
+}
+
+// credsFromManagedIdentity fetches a token from IMDS, available when
+// kubetest itself is running on an Azure VM with a managed identity
+// assigned. AZURE_SUBSCRIPTION_ID must still be set since IMDS has no
+// notion of "the" subscription.
+func credsFromManagedIdentity() (*Creds, error) {
+	subscriptionID := os.Getenv("AZURE_SUBSCRIPTION_ID")
+	if subscriptionID == "" {
+		return nil, nil
+	}
+	msiEndpoint, err := adal.GetMSIVMEndpoint()
+	if err != nil {
+		return nil, fmt.Errorf("Error building MSI endpoint: %v", err)
+	}
+	spt, err := adal.NewServicePrincipalTokenFromMSI(msiEndpoint, azureManagementResource)
+	if err != nil {
+		return nil, fmt.Errorf("Error building MSI token: %v", err)
+	}
+	if err := spt.Refresh(); err != nil {
+		// no managed identity assigned to this VM: not an error, just
+		// not applicable here.
+		return nil, nil
+	}
+	return &Creds{
+		SubscriptionID:     subscriptionID,
+		StorageAccountName: *acsStorageAccountName,
+		Authorizer:         autorest.NewBearerAuthorizer(spt),
+	}, nil
+}
+
+// credsFromFile is the original --acsengine-creds TOML file, kept as
+// the last resort for environments that don't export the env vars
+// above.
+func credsFromFile() (*Creds, error) {
+	if *acsCredentialsFile == "" {
+		return nil, nil
+	}
+	content, err := ioutil.ReadFile(*acsCredentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading credentials file %v: %v", *acsCredentialsFile, err)
+	}
+	config := Config{}
+	if err := toml.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("Error parsing credentials file %v: %v", *acsCredentialsFile, err)
+	}
+	return &config.Creds, nil
+}
+
+const (
+	azurePublicCloudADEndpoint = "https://login.microsoftonline.com"
+	azureManagementResource    = "https://management.azure.com/"
+	azureStorageResource       = "https://storage.azure.com/"
+)
+
+// storageCredential picks a blob storage credential for uploadZip:
+// the account key when one was resolved (from the TOML file), or an
+// Azure AD token for the same principal when key-based auth is
+// disabled by policy.
+func storageCredential(creds *Creds) (azblob.Credential, error) {
+	if creds.StorageAccountKey != "" {
+		return azblob.NewSharedKeyCredential(creds.StorageAccountName, creds.StorageAccountKey), nil
+	}
+	token, err := acquireStorageToken(creds)
+	if err != nil {
+		return nil, fmt.Errorf("Error acquiring Azure AD token for storage: %v", err)
+	}
+	return azblob.NewTokenCredential(token, nil), nil
+}
+
+// acquireStorageToken gets an AAD token for azureStorageResource using
+// whichever principal getAzCredentialChain resolved: workload identity,
+// a client secret, or the VM's managed identity, in that order.
+func acquireStorageToken(creds *Creds) (string, error) {
+	if tokenFile := os.Getenv("AZURE_FEDERATED_TOKEN_FILE"); tokenFile != "" && creds.ClientID != "" && creds.TenantID != "" {
+		assertion, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return "", fmt.Errorf("Error reading AZURE_FEDERATED_TOKEN_FILE %v: %v", tokenFile, err)
+		}
+		oauthConfig, err := adal.NewOAuthConfig(azurePublicCloudADEndpoint, creds.TenantID)
+		if err != nil {
+			return "", err
+		}
+		spt, err := adal.NewServicePrincipalTokenFromFederatedToken(*oauthConfig, creds.ClientID, string(assertion), azureStorageResource)
+		if err != nil {
+			return "", err
+		}
+		if err := spt.Refresh(); err != nil {
+			return "", err
+		}
+		return spt.OAuthToken(), nil
+	}
+	if creds.ClientID != "" && creds.ClientSecret != "" && creds.TenantID != "" {
+		oauthConfig, err := adal.NewOAuthConfig(azurePublicCloudADEndpoint, creds.TenantID)
+		if err != nil {
+			return "", err
+		}
+		spt, err := adal.NewServicePrincipalToken(*oauthConfig, creds.ClientID, creds.ClientSecret, azureStorageResource)
+		if err != nil {
+			return "", err
+		}
+		if err := spt.Refresh(); err != nil {
+			return "", err
+		}
+		return spt.OAuthToken(), nil
+	}
+	msiEndpoint, err := adal.GetMSIVMEndpoint()
+	if err != nil {
+		return "", err
+	}
+	spt, err := adal.NewServicePrincipalTokenFromMSI(msiEndpoint, azureStorageResource)
+	if err != nil {
+		return "", err
+	}
+	if err := spt.Refresh(); err != nil {
+		return "", err
+	}
+	return spt.OAuthToken(), nil
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure/azure-storage-blob-go/2016-05-31/azblob"
+)
+
+// linuxLogCommands are run directly against the master over SSH.
+var linuxLogCommands = map[string]string{
+	"cluster-info.txt":   "kubectl cluster-info dump",
+	"get-all.txt":        "kubectl get all --all-namespaces -o wide",
+	"describe-nodes.txt": "kubectl describe nodes",
+	"kubelet.log":        "sudo journalctl -u kubelet --no-pager",
+	"containerd.log":     "sudo journalctl -u containerd --no-pager",
+	"kube-proxy.log":     "sudo journalctl -u kube-proxy --no-pager",
+}
+
+// windowsLogCommands are run on each Windows agent, proxied through the
+// Linux master since the agents have no public IP of their own.
+var windowsLogCommands = map[string]string{
+	"kubelet-and-kubeproxy.zip": `powershell.exe -Command "Compress-Archive -Path C:\k\*.log,C:\k\kubeproxy\* -DestinationPath C:\k\logs.zip -Force; $b = [IO.File]::ReadAllBytes('C:\k\logs.zip'); $o = [Console]::OpenStandardOutput(); $o.Write($b, 0, $b.Length); $o.Flush()"`,
+	"hns-events.txt":            `powershell.exe -Command "Get-WinEvent -LogName Microsoft-Windows-Host-Network-Service-Admin | Format-List"`,
+	"cni-conflist.txt":          `powershell.exe -Command "Get-Content C:\k\cni\config\*.conflist"`,
+}
+
+// DumpClusterLogs SSHes into the Linux master and, through it, each
+// Windows agent to collect the logs CI failure triage actually needs,
+// then ships the result to Blob storage or GCS.
+func (c Cluster) DumpClusterLogs(localPath, gcsPath string) error {
+	log.Printf("Dumping cluster logs to %v (and %v).", localPath, gcsPath)
+
+	masterIP, err := c.azureClient.GetMasterPublicIP(c.ctx, c.resourceGroup)
+	if err != nil {
+		return fmt.Errorf("Error looking up master public IP: %v", err)
+	}
+	keyPath := strings.TrimSuffix(*acsSSHPublicKeyPath, ".pub")
+
+	masterDir := filepath.Join(localPath, "master")
+	if err := os.MkdirAll(masterDir, 0755); err != nil {
+		return fmt.Errorf("Error creating %v: %v", masterDir, err)
+	}
+	for name, cmd := range linuxLogCommands {
+		if err := runSSHToFile(masterIP, keyPath, cmd, filepath.Join(masterDir, name)); err != nil {
+			log.Printf("Error collecting %v from master: %v", name, err)
+		}
+	}
+
+	windowsNodes, err := listWindowsAgentIPs(masterIP, keyPath)
+	if err != nil {
+		return fmt.Errorf("Error listing Windows agents: %v", err)
+	}
+	for _, nodeIP := range windowsNodes {
+		nodeDir := filepath.Join(localPath, "agents", nodeIP)
+		if err := os.MkdirAll(nodeDir, 0755); err != nil {
+			log.Printf("Error creating %v: %v", nodeDir, err)
+			continue
+		}
+		for name, cmd := range windowsLogCommands {
+			if err := runSSHThroughMasterToFile(masterIP, nodeIP, keyPath, cmd, filepath.Join(nodeDir, name)); err != nil {
+				log.Printf("Error collecting %v from Windows node %v: %v", name, nodeIP, err)
+			}
+		}
+	}
+
+	if strings.HasPrefix(gcsPath, "https://") && strings.Contains(gcsPath, ".blob.core.windows.net/") {
+		return c.uploadLogsToBlob(localPath, gcsPath)
+	}
+	return control.FinishRunning(exec.Command("gsutil", "-m", "cp", "-r", localPath, gcsPath))
+}
+
+// runSSHToFile runs cmd on host over SSH and writes stdout to outPath.
+func runSSHToFile(host, keyPath, cmd, outPath string) error {
+	out, err := control.Output(sshCommand(host, keyPath, cmd))
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(outPath, out, 0644)
+}
+
+// runSSHThroughMasterToFile runs cmd on nodeIP by hopping through the
+// Linux master's SSH endpoint, since Windows agents only have private
+// IPs, and writes stdout to outPath.
+func runSSHThroughMasterToFile(masterHost, nodeIP, keyPath, cmd, outPath string) error {
+	proxyCmd := fmt.Sprintf("ssh -i %s -o StrictHostKeyChecking=no -W %%h:%%p %s@%s", keyPath, *acsAdminUsername, masterHost)
+	sshArgs := []string{
+		"-i", keyPath,
+		"-o", "StrictHostKeyChecking=no",
+		"-o", fmt.Sprintf("ProxyCommand=%s", proxyCmd),
+		fmt.Sprintf("%s@%s", *acsAdminUsername, nodeIP),
+		cmd,
+	}
+	out, err := control.Output(exec.Command("ssh", sshArgs...))
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(outPath, out, 0644)
+}
+
+func sshCommand(host, keyPath, cmd string) *exec.Cmd {
+	return exec.Command("ssh",
+		"-i", keyPath,
+		"-o", "StrictHostKeyChecking=no",
+		fmt.Sprintf("%s@%s", *acsAdminUsername, host),
+		cmd)
+}
+
+// listWindowsAgentIPs asks the master's kubectl for the internal IPs of
+// every node labeled kubernetes.io/os=windows.
+func listWindowsAgentIPs(masterHost, keyPath string) ([]string, error) {
+	out, err := control.Output(sshCommand(masterHost, keyPath,
+		`kubectl get nodes -l kubernetes.io/os=windows -o json`))
+	if err != nil {
+		return nil, err
+	}
+	var nodeList struct {
+		Items []struct {
+			Status struct {
+				Addresses []struct {
+					Type    string `json:"type"`
+					Address string `json:"address"`
+				} `json:"addresses"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(out, &nodeList); err != nil {
+		return nil, fmt.Errorf("Error parsing node list: %v", err)
+	}
+	var ips []string
+	for _, node := range nodeList.Items {
+		for _, addr := range node.Status.Addresses {
+			if addr.Type == "InternalIP" {
+				ips = append(ips, addr.Address)
+			}
+		}
+	}
+	return ips, nil
+}
+
+// uploadLogsToBlob zips localPath and uploads it to the container
+// referenced by blobURL, reusing the same pipeline setup as uploadZip.
+func (c Cluster) uploadLogsToBlob(localPath, blobURL string) error {
+	zipPath := localPath + ".zip"
+	if err := zipDir(localPath, zipPath); err != nil {
+		return fmt.Errorf("Error zipping %v: %v", localPath, err)
+	}
+
+	credential, err := storageCredential(c.credentials)
+	if err != nil {
+		return fmt.Errorf("Error getting storage credential: %v", err)
+	}
+	p := azblob.NewPipeline(credential, azblob.PipelineOptions{})
+	URL, err := url.Parse(blobURL)
+	if err != nil {
+		return fmt.Errorf("Error parsing blob URL %v: %v", blobURL, err)
+	}
+	containerURL := azblob.NewContainerURL(*URL, p)
+	file, err := os.Open(zipPath)
+	if err != nil {
+		return fmt.Errorf("Failed to open file %v . Error %v", zipPath, err)
+	}
+	defer file.Close()
+	blobURLClient := containerURL.NewBlockBlobURL(filepath.Base(zipPath))
+	_, err = azblob.UploadFileToBlockBlob(context.Background(), file, blobURLClient, azblob.UploadToBlockBlobOptions{})
+	return err
+}
+
+func zipDir(srcDir, destZip string) error {
+	zipFile, err := os.Create(destZip)
+	if err != nil {
+		return err
+	}
+	defer zipFile.Close()
+	zw := zip.NewWriter(zipFile)
+	defer zw.Close()
+
+	return filepath.Walk(srcDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(srcDir, filePath)
+		if err != nil {
+			return err
+		}
+		w, err := zw.Create(relPath)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	})
+}